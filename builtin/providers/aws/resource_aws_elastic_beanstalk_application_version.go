@@ -3,14 +3,21 @@ package aws
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk/elasticbeanstalkiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 func resourceAwsElasticBeanstalkApplicationVersion() *schema.Resource {
@@ -53,6 +60,94 @@ func resourceAwsElasticBeanstalkApplicationVersion() *schema.Resource {
 				Type:     schema.TypeInt,
 				Optional: true,
 			},
+			"delete_source_bundle": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"retention_concurrency": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  4,
+			},
+			"process": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			"status": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tags": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+			},
+			"retention_policy": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"count": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"keep": &schema.Schema{
+										Type:     schema.TypeInt,
+										Required: true,
+									},
+								},
+							},
+						},
+						"age": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"max_age_hours": &schema.Schema{
+										Type:     schema.TypeInt,
+										Required: true,
+									},
+								},
+							},
+						},
+						"regex_group": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"pattern": &schema.Schema{
+										Type:     schema.TypeString,
+										Required: true,
+									},
+									"keep_per_group": &schema.Schema{
+										Type:     schema.TypeInt,
+										Required: true,
+									},
+								},
+							},
+						},
+						"total_size": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"max_total_bytes": &schema.Schema{
+										Type:     schema.TypeInt,
+										Required: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
 		},
 	}
 }
@@ -65,6 +160,7 @@ func resourceAwsElasticBeanstalkApplicationVersionCreate(d *schema.ResourceData,
 	bucket := d.Get("bucket").(string)
 	key := d.Get("key").(string)
 	name := d.Get("name").(string)
+	process := d.Get("process").(bool)
 
 	s3Location := elasticbeanstalk.S3Location{
 		S3Bucket: aws.String(bucket),
@@ -76,6 +172,8 @@ func resourceAwsElasticBeanstalkApplicationVersionCreate(d *schema.ResourceData,
 		Description:     aws.String(description),
 		SourceBundle:    &s3Location,
 		VersionLabel:    aws.String(name),
+		Process:         aws.Bool(process),
+		Tags:            tagsFromMapEB(d.Get("tags").(map[string]interface{})),
 	}
 
 	log.Printf("[DEBUG] Elastic Beanstalk Application Version create opts: %s", createOpts)
@@ -87,9 +185,84 @@ func resourceAwsElasticBeanstalkApplicationVersionCreate(d *schema.ResourceData,
 	d.SetId(name)
 	log.Printf("[INFO] Elastic Beanstalk Application Version Label: %s", name)
 
+	if process {
+		if err := waitForApplicationVersionProcessed(conn, application, name); err != nil {
+			return err
+		}
+	}
+
 	return resourceAwsElasticBeanstalkApplicationVersionRead(d, meta)
 }
 
+// waitForApplicationVersionProcessed polls DescribeApplicationVersions until
+// the version's Status leaves "Processing", mirroring the
+// environmentStateRefreshFunc pattern used to wait on environment updates.
+func waitForApplicationVersionProcessed(conn elasticbeanstalkiface.ElasticBeanstalkAPI, application string, name string) error {
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"Processing"},
+		Target:  []string{"Processed", "Failed", "Unprocessed"},
+		Refresh: applicationVersionStateRefreshFunc(conn, application, name),
+		Timeout: 20 * time.Minute,
+		Delay:   5 * time.Second,
+	}
+
+	raw, err := stateConf.WaitForState()
+	if err != nil {
+		return fmt.Errorf("Error waiting for Elastic Beanstalk Application Version (%s) to process: %s", name, err)
+	}
+
+	version := raw.(*elasticbeanstalk.ApplicationVersionDescription)
+	if aws.StringValue(version.Status) == "Failed" {
+		return fmt.Errorf("Elastic Beanstalk Application Version (%s) failed to process the source bundle: %s", name, applicationVersionFailureReason(conn, application, name))
+	}
+
+	return nil
+}
+
+// applicationVersionFailureReason looks up the most recent ERROR-severity
+// event for the version so a processing failure surfaces Beanstalk's actual
+// manifest validation error instead of a generic message.
+func applicationVersionFailureReason(conn elasticbeanstalkiface.ElasticBeanstalkAPI, application string, name string) string {
+	resp, err := conn.DescribeEvents(&elasticbeanstalk.DescribeEventsInput{
+		ApplicationName: aws.String(application),
+		VersionLabel:    aws.String(name),
+		Severity:        aws.String("ERROR"),
+		MaxRecords:      aws.Int64(1),
+	})
+	if err != nil {
+		return fmt.Sprintf("no additional detail available from Elastic Beanstalk: %s", err)
+	}
+	if len(resp.Events) == 0 {
+		return "no additional detail available from Elastic Beanstalk"
+	}
+
+	return aws.StringValue(resp.Events[0].Message)
+}
+
+func applicationVersionStateRefreshFunc(conn elasticbeanstalkiface.ElasticBeanstalkAPI, application string, name string) resource.StateRefreshFunc {
+	return func() (interface{}, string, error) {
+		resp, err := conn.DescribeApplicationVersions(&elasticbeanstalk.DescribeApplicationVersionsInput{
+			ApplicationName: aws.String(application),
+			VersionLabels:   []*string{aws.String(name)},
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("Error on retrieving Elastic Beanstalk Application Version details: %s", err)
+		}
+
+		if len(resp.ApplicationVersions) == 0 {
+			// CreateApplicationVersion can return before the version is
+			// visible to DescribeApplicationVersions. Treat that as a
+			// transient gap rather than a hard failure, same as
+			// environmentStateRefreshFunc tolerates a not-yet-visible
+			// environment.
+			return nil, "Processing", nil
+		}
+
+		version := resp.ApplicationVersions[0]
+		return version, aws.StringValue(version.Status), nil
+	}
+}
+
 func resourceAwsElasticBeanstalkApplicationVersionRead(d *schema.ResourceData, meta interface{}) error {
 	conn := meta.(*AWSClient).elasticbeanstalkconn
 
@@ -115,6 +288,22 @@ func resourceAwsElasticBeanstalkApplicationVersionRead(d *schema.ResourceData, m
 		return err
 	}
 
+	if err := d.Set("status", resp.ApplicationVersions[0].Status); err != nil {
+		return err
+	}
+
+	arn := aws.StringValue(resp.ApplicationVersions[0].ApplicationVersionArn)
+	tagsResp, err := conn.ListTagsForResource(&elasticbeanstalk.ListTagsForResourceInput{
+		ResourceArn: aws.String(arn),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := d.Set("tags", tagsToMapEB(tagsResp.ResourceTags)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -127,10 +316,46 @@ func resourceAwsElasticBeanstalkApplicationVersionUpdate(d *schema.ResourceData,
 		}
 	}
 
+	if d.HasChange("tags") {
+		if err := resourceAwsElasticBeanstalkApplicationVersionTagsUpdate(conn, d); err != nil {
+			return err
+		}
+	}
+
 	return resourceAwsElasticBeanstalkApplicationVersionRead(d, meta)
 
 }
 
+func resourceAwsElasticBeanstalkApplicationVersionTagsUpdate(conn *elasticbeanstalk.ElasticBeanstalk, d *schema.ResourceData) error {
+	application := d.Get("application").(string)
+	name := d.Get("name").(string)
+
+	resp, err := conn.DescribeApplicationVersions(&elasticbeanstalk.DescribeApplicationVersionsInput{
+		ApplicationName: aws.String(application),
+		VersionLabels:   []*string{aws.String(name)},
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.ApplicationVersions) != 1 {
+		return fmt.Errorf("Error reading application version properties: found %d application versions, expected 1", len(resp.ApplicationVersions))
+	}
+
+	arn := aws.StringValue(resp.ApplicationVersions[0].ApplicationVersionArn)
+	o, n := d.GetChange("tags")
+	tagsToAdd, tagsToRemove := diffTagsEB(o.(map[string]interface{}), n.(map[string]interface{}))
+
+	log.Printf("[DEBUG] Elastic Beanstalk application version: %s, update tags: add %#v, remove %#v", name, tagsToAdd, tagsToRemove)
+
+	_, err = conn.UpdateTagsForResource(&elasticbeanstalk.UpdateTagsForResourceInput{
+		ResourceArn:  aws.String(arn),
+		TagsToAdd:    tagsToAdd,
+		TagsToRemove: tagsToRemove,
+	})
+
+	return err
+}
+
 func resourceAwsElasticBeanstalkApplicationVersionDescriptionUpdate(conn *elasticbeanstalk.ElasticBeanstalk, d *schema.ResourceData) error {
 	application := d.Get("application").(string)
 	description := d.Get("description").(string)
@@ -154,14 +379,16 @@ func resourceAwsElasticBeanstalkApplicationVersionDelete(d *schema.ResourceData,
 	name := d.Id()
 	retentionNumber := d.Get("retention_number").(int)
 	retentionPeriod := d.Get("retention_period").(int)
+	retentionConcurrency := d.Get("retention_concurrency").(int)
+	deleteSourceBundle := d.Get("delete_source_bundle").(bool)
+	retentionPolicyConfig := d.Get("retention_policy").([]interface{})
 
-	if retentionNumber == 0 {
+	if retentionNumber == 0 && len(retentionPolicyConfig) == 0 {
 		log.Printf("[DEBUG] retentionNumber and retentionPeriod not set. Deleteting %s", name)
-		if err := deleteApplicationVersion(conn, application, name); err != nil {
+		if err := deleteApplicationVersion(conn, application, name, deleteSourceBundle); err != nil {
 			return err
 		}
 	} else {
-		log.Printf("[DEBUG] retentionNumber: %d retentionPeriod: %d", retentionNumber, retentionPeriod)
 		versions, err := conn.DescribeApplicationVersions(&elasticbeanstalk.DescribeApplicationVersionsInput{
 			ApplicationName: aws.String(application),
 		})
@@ -170,21 +397,60 @@ func resourceAwsElasticBeanstalkApplicationVersionDelete(d *schema.ResourceData,
 			return err
 		}
 
-		for _, v := range applicationVersions(versions.ApplicationVersions, retentionNumber, retentionPeriod) {
-			if err = deleteApplicationVersion(conn, application, *v); err != nil {
+		var candidates []*elasticbeanstalk.ApplicationVersionDescription
+		if len(retentionPolicyConfig) > 0 {
+			policies, err := expandApplicationVersionRetentionPolicies(meta.(*AWSClient).s3conn, retentionPolicyConfig)
+			if err != nil {
+				return err
+			}
+
+			deployed, err := deployedApplicationVersions(conn, application)
+			if err != nil {
 				return err
 			}
+
+			candidates = deletableApplicationVersions(versions.ApplicationVersions, policies, deployed)
+		} else {
+			log.Printf("[DEBUG] retentionNumber: %d retentionPeriod: %d", retentionNumber, retentionPeriod)
+			candidates = applicationVersions(versions.ApplicationVersions, retentionNumber, retentionPeriod)
+		}
+
+		pruner := NewApplicationVersionPruner(conn, application, retentionConcurrency, deleteSourceBundle)
+		if err := pruner.Prune(candidates); err != nil {
+			return err
 		}
 	}
 	d.SetId("")
 	return nil
 }
 
-func deleteApplicationVersion(conn *elasticbeanstalk.ElasticBeanstalk, application string, v string) error {
-	log.Printf("[DEBUG] Deleting Application Version: %s", v)
-	_, err := conn.DeleteApplicationVersion(&elasticbeanstalk.DeleteApplicationVersionInput{
+// deployedApplicationVersions returns the set of version labels currently
+// deployed to any environment of application, so retention policies never
+// delete a version that is live.
+func deployedApplicationVersions(conn *elasticbeanstalk.ElasticBeanstalk, application string) (map[string]bool, error) {
+	resp, err := conn.DescribeEnvironments(&elasticbeanstalk.DescribeEnvironmentsInput{
 		ApplicationName: aws.String(application),
-		VersionLabel:    aws.String(v),
+		IncludeDeleted:  aws.Bool(false),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deployed := make(map[string]bool, len(resp.Environments))
+	for _, e := range resp.Environments {
+		if e.VersionLabel != nil {
+			deployed[aws.StringValue(e.VersionLabel)] = true
+		}
+	}
+	return deployed, nil
+}
+
+func deleteApplicationVersion(conn elasticbeanstalkiface.ElasticBeanstalkAPI, application string, v string, deleteSourceBundle bool) error {
+	log.Printf("[DEBUG] Deleting Application Version: %s (delete_source_bundle: %t)", v, deleteSourceBundle)
+	_, err := conn.DeleteApplicationVersion(&elasticbeanstalk.DeleteApplicationVersionInput{
+		ApplicationName:    aws.String(application),
+		VersionLabel:       aws.String(v),
+		DeleteSourceBundle: aws.Bool(deleteSourceBundle),
 	})
 
 	if err != nil {
@@ -199,8 +465,8 @@ func deleteApplicationVersion(conn *elasticbeanstalk.ElasticBeanstalk, applicati
 	return nil
 }
 
-func applicationVersions(versions []*elasticbeanstalk.ApplicationVersionDescription, retentionNumber int, retentionPeriod int) []*string {
-	var versionsToDelete []*string
+func applicationVersions(versions []*elasticbeanstalk.ApplicationVersionDescription, retentionNumber int, retentionPeriod int) []*elasticbeanstalk.ApplicationVersionDescription {
+	var versionsToDelete []*elasticbeanstalk.ApplicationVersionDescription
 	retentionPeriodHours := time.Duration(retentionPeriod) * time.Hour
 
 	versionSlice := applicationVersionDescriptionSlice(versions)
@@ -219,10 +485,10 @@ func applicationVersions(versions []*elasticbeanstalk.ApplicationVersionDescript
 	for _, v := range versionSlice {
 		if retentionPeriod != 0 {
 			if time.Since(*v.DateCreated) > retentionPeriodHours {
-				versionsToDelete = append(versionsToDelete, v.VersionLabel)
+				versionsToDelete = append(versionsToDelete, v)
 			}
 		} else {
-			versionsToDelete = append(versionsToDelete, v.VersionLabel)
+			versionsToDelete = append(versionsToDelete, v)
 		}
 	}
 
@@ -230,6 +496,316 @@ func applicationVersions(versions []*elasticbeanstalk.ApplicationVersionDescript
 	return versionsToDelete
 }
 
+// sortedApplicationVersions returns a copy of versions sorted most recent to
+// oldest, leaving the input slice untouched.
+func sortedApplicationVersions(versions []*elasticbeanstalk.ApplicationVersionDescription) []*elasticbeanstalk.ApplicationVersionDescription {
+	sorted := make(applicationVersionDescriptionSlice, len(versions))
+	copy(sorted, versions)
+	sort.Sort(sorted)
+	return sorted
+}
+
+// retentionPolicy decides which of a set of application versions are safe to
+// delete. Each policy is evaluated independently; deletableApplicationVersions
+// only deletes a version that every configured policy agrees is deletable, so
+// combining policies can only ever shrink what gets pruned. Unexported, unlike
+// ApplicationVersionPruner — nothing outside the package needs these.
+type retentionPolicy interface {
+	deletableVersions(versions []*elasticbeanstalk.ApplicationVersionDescription) map[string]bool
+}
+
+// countPolicy keeps the keep newest versions and marks everything older as
+// deletable. It is the retention_policy counterpart of the legacy
+// retention_number attribute, which applicationVersions still handles on its own.
+type countPolicy struct {
+	keep int
+}
+
+func (p countPolicy) deletableVersions(versions []*elasticbeanstalk.ApplicationVersionDescription) map[string]bool {
+	deletable := make(map[string]bool)
+
+	if p.keep <= 0 {
+		return deletable
+	}
+
+	sorted := sortedApplicationVersions(versions)
+	if len(sorted) <= p.keep {
+		return deletable
+	}
+
+	for _, v := range sorted[p.keep:] {
+		deletable[aws.StringValue(v.VersionLabel)] = true
+	}
+	return deletable
+}
+
+// agePolicy marks any version older than maxAge as deletable. It is the
+// retention_policy counterpart of the legacy retention_period attribute,
+// which applicationVersions still handles on its own.
+type agePolicy struct {
+	maxAge time.Duration
+}
+
+func (p agePolicy) deletableVersions(versions []*elasticbeanstalk.ApplicationVersionDescription) map[string]bool {
+	deletable := make(map[string]bool)
+	for _, v := range versions {
+		if v.DateCreated != nil && time.Since(*v.DateCreated) > p.maxAge {
+			deletable[aws.StringValue(v.VersionLabel)] = true
+		}
+	}
+	return deletable
+}
+
+// regexGroupPolicy groups version labels by the first capture group of
+// pattern (falling back to the whole label for non-matching versions) and
+// keeps the keepPerGroup newest versions within each group, e.g. the newest N
+// versions per git branch prefix.
+type regexGroupPolicy struct {
+	pattern      *regexp.Regexp
+	keepPerGroup int
+}
+
+func (p regexGroupPolicy) deletableVersions(versions []*elasticbeanstalk.ApplicationVersionDescription) map[string]bool {
+	groups := make(map[string][]*elasticbeanstalk.ApplicationVersionDescription)
+	for _, v := range versions {
+		label := aws.StringValue(v.VersionLabel)
+		key := label
+		if m := p.pattern.FindStringSubmatch(label); len(m) > 1 {
+			key = m[1]
+		}
+		groups[key] = append(groups[key], v)
+	}
+
+	deletable := make(map[string]bool)
+	if p.keepPerGroup <= 0 {
+		return deletable
+	}
+
+	for _, group := range groups {
+		sorted := sortedApplicationVersions(group)
+		if len(sorted) <= p.keepPerGroup {
+			continue
+		}
+		for _, v := range sorted[p.keepPerGroup:] {
+			deletable[aws.StringValue(v.VersionLabel)] = true
+		}
+	}
+	return deletable
+}
+
+// totalSizePolicy evicts the oldest versions, by S3 source bundle size, until
+// the remaining versions fit under maxTotalBytes.
+type totalSizePolicy struct {
+	maxTotalBytes int64
+	s3conn        s3iface.S3API
+}
+
+func (p totalSizePolicy) deletableVersions(versions []*elasticbeanstalk.ApplicationVersionDescription) map[string]bool {
+	sorted := sortedApplicationVersions(versions)
+
+	sizes := make(map[string]int64, len(sorted))
+	var total int64
+	for _, v := range sorted {
+		size, err := p.sourceBundleSize(v)
+		if err != nil {
+			log.Printf("[WARN] Could not determine source bundle size for Elastic Beanstalk Application Version %s: %s", aws.StringValue(v.VersionLabel), err)
+			continue
+		}
+		sizes[aws.StringValue(v.VersionLabel)] = size
+		total += size
+	}
+
+	deletable := make(map[string]bool)
+	for i := len(sorted) - 1; i >= 0 && total > p.maxTotalBytes; i-- {
+		label := aws.StringValue(sorted[i].VersionLabel)
+		size, ok := sizes[label]
+		if !ok {
+			continue
+		}
+		deletable[label] = true
+		total -= size
+	}
+	return deletable
+}
+
+func (p totalSizePolicy) sourceBundleSize(v *elasticbeanstalk.ApplicationVersionDescription) (int64, error) {
+	if v.SourceBundle == nil {
+		return 0, nil
+	}
+
+	out, err := p.s3conn.HeadObject(&s3.HeadObjectInput{
+		Bucket: v.SourceBundle.S3Bucket,
+		Key:    v.SourceBundle.S3Key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(out.ContentLength), nil
+}
+
+// deletableApplicationVersions intersects the deletable sets of every policy
+// so a version is pruned only when all policies agree, and always excludes
+// deployed version labels regardless of what the policies decide.
+func deletableApplicationVersions(versions []*elasticbeanstalk.ApplicationVersionDescription, policies []retentionPolicy, deployed map[string]bool) []*elasticbeanstalk.ApplicationVersionDescription {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	deletable := policies[0].deletableVersions(versions)
+	for _, p := range policies[1:] {
+		next := p.deletableVersions(versions)
+		for label := range deletable {
+			if !next[label] {
+				delete(deletable, label)
+			}
+		}
+	}
+
+	var candidates []*elasticbeanstalk.ApplicationVersionDescription
+	for _, v := range versions {
+		label := aws.StringValue(v.VersionLabel)
+		if deletable[label] && !deployed[label] {
+			candidates = append(candidates, v)
+		}
+	}
+	return candidates
+}
+
+// expandApplicationVersionRetentionPolicies builds the retentionPolicy slice
+// described by a resource's retention_policy blocks.
+func expandApplicationVersionRetentionPolicies(s3conn s3iface.S3API, raw []interface{}) ([]retentionPolicy, error) {
+	policies := make([]retentionPolicy, 0, len(raw))
+
+	for _, r := range raw {
+		m := r.(map[string]interface{})
+
+		if v := m["count"].([]interface{}); len(v) == 1 {
+			cfg := v[0].(map[string]interface{})
+			policies = append(policies, countPolicy{keep: cfg["keep"].(int)})
+		}
+
+		if v := m["age"].([]interface{}); len(v) == 1 {
+			cfg := v[0].(map[string]interface{})
+			policies = append(policies, agePolicy{maxAge: time.Duration(cfg["max_age_hours"].(int)) * time.Hour})
+		}
+
+		if v := m["regex_group"].([]interface{}); len(v) == 1 {
+			cfg := v[0].(map[string]interface{})
+			re, err := regexp.Compile(cfg["pattern"].(string))
+			if err != nil {
+				return nil, fmt.Errorf("Error compiling retention_policy regex_group pattern: %s", err)
+			}
+			policies = append(policies, regexGroupPolicy{pattern: re, keepPerGroup: cfg["keep_per_group"].(int)})
+		}
+
+		if v := m["total_size"].([]interface{}); len(v) == 1 {
+			cfg := v[0].(map[string]interface{})
+			policies = append(policies, totalSizePolicy{maxTotalBytes: int64(cfg["max_total_bytes"].(int)), s3conn: s3conn})
+		}
+	}
+
+	return policies, nil
+}
+
+// ApplicationVersionPruner deletes a set of application versions concurrently,
+// retrying individual deletes that are throttled by the Beanstalk API instead
+// of stalling the whole batch behind a single backoff.
+//
+// It is exported, and takes the elasticbeanstalkiface.ElasticBeanstalkAPI
+// interface rather than a concrete client, so other callers, such as a future
+// data source or CLI utility, can reuse the same bounded, retrying delete
+// behavior outside the Delete lifecycle.
+type ApplicationVersionPruner struct {
+	conn               elasticbeanstalkiface.ElasticBeanstalkAPI
+	application        string
+	concurrency        int
+	deleteSourceBundle bool
+}
+
+func NewApplicationVersionPruner(conn elasticbeanstalkiface.ElasticBeanstalkAPI, application string, concurrency int, deleteSourceBundle bool) *ApplicationVersionPruner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &ApplicationVersionPruner{
+		conn:               conn,
+		application:        application,
+		concurrency:        concurrency,
+		deleteSourceBundle: deleteSourceBundle,
+	}
+}
+
+// Prune deletes versions using p.concurrency worker goroutines reading off of
+// a bounded channel. The channel is filled by this goroutine, so once it is
+// full, sends block until a worker frees up a slot, back-pressuring the
+// producer rather than buffering every candidate version in memory.
+func (p *ApplicationVersionPruner) Prune(versions []*elasticbeanstalk.ApplicationVersionDescription) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	queue := make(chan *elasticbeanstalk.ApplicationVersionDescription, p.concurrency)
+	errs := make(chan error, len(versions))
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range queue {
+				if err := p.deleteWithBackoff(v); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	for _, v := range versions {
+		queue <- v
+	}
+	close(queue)
+
+	wg.Wait()
+	close(errs)
+
+	var errMessages []string
+	for err := range errs {
+		errMessages = append(errMessages, err.Error())
+	}
+	if len(errMessages) > 0 {
+		return fmt.Errorf("error pruning Elastic Beanstalk Application Versions: %s", strings.Join(errMessages, "; "))
+	}
+
+	return nil
+}
+
+// deleteWithBackoff retries a single delete with exponential backoff when the
+// Beanstalk API throttles the request, leaving non-throttling errors to
+// propagate immediately.
+func (p *ApplicationVersionPruner) deleteWithBackoff(v *elasticbeanstalk.ApplicationVersionDescription) error {
+	const maxAttempts = 5
+	backoff := 1 * time.Second
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = deleteApplicationVersion(p.conn, p.application, *v.VersionLabel, p.deleteSourceBundle)
+		if err == nil {
+			return nil
+		}
+
+		awsErr, ok := err.(awserr.Error)
+		if !ok || (awsErr.Code() != "Throttling" && awsErr.Code() != "TooManyRequestsException") {
+			return err
+		}
+
+		log.Printf("[DEBUG] Throttled pruning Elastic Beanstalk Application Version %s, retrying in %s (attempt %d/%d)", *v.VersionLabel, backoff, attempt, maxAttempts)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return fmt.Errorf("giving up pruning Elastic Beanstalk Application Version %s after %d attempts: %s", *v.VersionLabel, maxAttempts, err)
+}
+
 // To make sure the application versions are always sorted we implement the sort interface
 // for our local ApplicationVersionDescription slice type. Sort order is most recent to oldest.
 type applicationVersionDescriptionSlice []*elasticbeanstalk.ApplicationVersionDescription
@@ -245,3 +821,51 @@ func (slice applicationVersionDescriptionSlice) Less(i, j int) bool {
 func (slice applicationVersionDescriptionSlice) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
+
+// tagsFromMapEB turns a Terraform tags map into the []*elasticbeanstalk.Tag
+// shape CreateApplicationVersionInput expects.
+func tagsFromMapEB(m map[string]interface{}) []*elasticbeanstalk.Tag {
+	tags := make([]*elasticbeanstalk.Tag, 0, len(m))
+	for k, v := range m {
+		tags = append(tags, &elasticbeanstalk.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(v.(string)),
+		})
+	}
+	return tags
+}
+
+// tagsToMapEB is the inverse of tagsFromMapEB, used when reading tags back
+// from ListTagsForResource.
+func tagsToMapEB(tags []*elasticbeanstalk.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return m
+}
+
+// diffTagsEB computes the []*elasticbeanstalk.Tag to add/update and the
+// []*string of keys to remove so UpdateTagsForResource only touches what
+// changed between the old and new tags maps.
+func diffTagsEB(oldTags map[string]interface{}, newTags map[string]interface{}) ([]*elasticbeanstalk.Tag, []*string) {
+	var tagsToAdd []*elasticbeanstalk.Tag
+	for k, v := range newTags {
+		old, ok := oldTags[k]
+		if !ok || old.(string) != v.(string) {
+			tagsToAdd = append(tagsToAdd, &elasticbeanstalk.Tag{
+				Key:   aws.String(k),
+				Value: aws.String(v.(string)),
+			})
+		}
+	}
+
+	var tagsToRemove []*string
+	for k := range oldTags {
+		if _, ok := newTags[k]; !ok {
+			tagsToRemove = append(tagsToRemove, aws.String(k))
+		}
+	}
+
+	return tagsToAdd, tagsToRemove
+}
@@ -0,0 +1,733 @@
+package aws
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/acctest"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk/elasticbeanstalkiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// mockElasticBeanstalkConn implements just enough of
+// elasticbeanstalkiface.ElasticBeanstalkAPI to drive ApplicationVersionPruner
+// in tests, embedding the interface so the rest of the (large) surface is
+// satisfied without having to stub every method.
+type mockElasticBeanstalkConn struct {
+	elasticbeanstalkiface.ElasticBeanstalkAPI
+
+	mu               sync.Mutex
+	deleted          []string
+	throttleAttempts map[string]int
+	failWith         map[string]string
+
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (m *mockElasticBeanstalkConn) DeleteApplicationVersion(input *elasticbeanstalk.DeleteApplicationVersionInput) (*elasticbeanstalk.DeleteApplicationVersionOutput, error) {
+	cur := atomic.AddInt32(&m.inFlight, 1)
+	defer atomic.AddInt32(&m.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&m.maxInFlight)
+		if cur <= max || atomic.CompareAndSwapInt32(&m.maxInFlight, max, cur) {
+			break
+		}
+	}
+
+	// give other workers a chance to start so maxInFlight reflects real concurrency.
+	time.Sleep(10 * time.Millisecond)
+
+	label := aws.StringValue(input.VersionLabel)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.throttleAttempts[label] > 0 {
+		m.throttleAttempts[label]--
+		return nil, awserr.New("Throttling", "rate exceeded", nil)
+	}
+
+	if code, ok := m.failWith[label]; ok {
+		return nil, awserr.New(code, "permanent failure", nil)
+	}
+
+	m.deleted = append(m.deleted, label)
+	return &elasticbeanstalk.DeleteApplicationVersionOutput{}, nil
+}
+
+func syntheticApplicationVersions(labels ...string) []*elasticbeanstalk.ApplicationVersionDescription {
+	versions := make([]*elasticbeanstalk.ApplicationVersionDescription, len(labels))
+	for i, label := range labels {
+		versions[i] = &elasticbeanstalk.ApplicationVersionDescription{VersionLabel: aws.String(label)}
+	}
+	return versions
+}
+
+func TestApplicationVersionPruner_Prune(t *testing.T) {
+	testCases := []struct {
+		name           string
+		concurrency    int
+		labels         []string
+		throttle       map[string]int
+		failWith       map[string]string
+		wantDeleted    []string
+		wantErrContain string
+	}{
+		{
+			name:        "deletes every candidate",
+			concurrency: 4,
+			labels:      []string{"v1", "v2", "v3", "v4", "v5"},
+			wantDeleted: []string{"v1", "v2", "v3", "v4", "v5"},
+		},
+		{
+			name:        "retries throttled deletes until they succeed",
+			concurrency: 2,
+			labels:      []string{"v1", "v2"},
+			throttle:    map[string]int{"v1": 2},
+			wantDeleted: []string{"v1", "v2"},
+		},
+		{
+			name:           "gives up and reports a permanent failure",
+			concurrency:    2,
+			labels:         []string{"v1", "v2"},
+			failWith:       map[string]string{"v2": "AccessDenied"},
+			wantDeleted:    []string{"v1"},
+			wantErrContain: "AccessDenied",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &mockElasticBeanstalkConn{
+				throttleAttempts: tc.throttle,
+				failWith:         tc.failWith,
+			}
+
+			pruner := NewApplicationVersionPruner(conn, "test-app", tc.concurrency, false)
+			err := pruner.Prune(syntheticApplicationVersions(tc.labels...))
+
+			if tc.wantErrContain != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErrContain) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErrContain, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			conn.mu.Lock()
+			deleted := append([]string(nil), conn.deleted...)
+			conn.mu.Unlock()
+
+			if len(deleted) != len(tc.wantDeleted) {
+				t.Fatalf("expected %d deletes, got %d (%v)", len(tc.wantDeleted), len(deleted), deleted)
+			}
+			seen := make(map[string]bool, len(deleted))
+			for _, label := range deleted {
+				seen[label] = true
+			}
+			for _, label := range tc.wantDeleted {
+				if !seen[label] {
+					t.Errorf("expected %s to have been deleted, deleted: %v", label, deleted)
+				}
+			}
+		})
+	}
+}
+
+func TestApplicationVersionPruner_Prune_BoundsConcurrency(t *testing.T) {
+	conn := &mockElasticBeanstalkConn{}
+	pruner := NewApplicationVersionPruner(conn, "test-app", 2, false)
+
+	labels := []string{"v1", "v2", "v3", "v4", "v5", "v6"}
+	if err := pruner.Prune(syntheticApplicationVersions(labels...)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if conn.maxInFlight > 2 {
+		t.Errorf("expected at most 2 concurrent deletes, observed %d", conn.maxInFlight)
+	}
+	if conn.maxInFlight < 2 {
+		t.Errorf("expected deletes to run concurrently, observed max in-flight of %d", conn.maxInFlight)
+	}
+}
+
+// mockElasticBeanstalkRefreshConn backs applicationVersionStateRefreshFunc and
+// applicationVersionFailureReason tests. DescribeApplicationVersions walks
+// through responses in order, repeating the last one, so a test can script a
+// transition (e.g. not-found, then found) across successive calls.
+type mockElasticBeanstalkRefreshConn struct {
+	elasticbeanstalkiface.ElasticBeanstalkAPI
+
+	responses []*elasticbeanstalk.DescribeApplicationVersionsOutput
+	call      int
+
+	eventMessage string
+}
+
+func (m *mockElasticBeanstalkRefreshConn) DescribeApplicationVersions(*elasticbeanstalk.DescribeApplicationVersionsInput) (*elasticbeanstalk.DescribeApplicationVersionsOutput, error) {
+	resp := m.responses[m.call]
+	if m.call < len(m.responses)-1 {
+		m.call++
+	}
+	return resp, nil
+}
+
+func (m *mockElasticBeanstalkRefreshConn) DescribeEvents(*elasticbeanstalk.DescribeEventsInput) (*elasticbeanstalk.DescribeEventsOutput, error) {
+	return &elasticbeanstalk.DescribeEventsOutput{
+		Events: []*elasticbeanstalk.EventDescription{{Message: aws.String(m.eventMessage)}},
+	}, nil
+}
+
+func applicationVersionDescribeOutput(status string) *elasticbeanstalk.DescribeApplicationVersionsOutput {
+	return &elasticbeanstalk.DescribeApplicationVersionsOutput{
+		ApplicationVersions: []*elasticbeanstalk.ApplicationVersionDescription{
+			{VersionLabel: aws.String("v1"), Status: aws.String(status)},
+		},
+	}
+}
+
+func TestApplicationVersionStateRefreshFunc(t *testing.T) {
+	t.Run("reports the target status once processing finishes", func(t *testing.T) {
+		conn := &mockElasticBeanstalkRefreshConn{
+			responses: []*elasticbeanstalk.DescribeApplicationVersionsOutput{
+				applicationVersionDescribeOutput("Processing"),
+				applicationVersionDescribeOutput("Processed"),
+			},
+		}
+		refresh := applicationVersionStateRefreshFunc(conn, "app", "v1")
+
+		if _, status, err := refresh(); err != nil || status != "Processing" {
+			t.Fatalf("expected Processing, got status=%q err=%v", status, err)
+		}
+		if _, status, err := refresh(); err != nil || status != "Processed" {
+			t.Fatalf("expected Processed, got status=%q err=%v", status, err)
+		}
+	})
+
+	t.Run("reports Failed without erroring, leaving the caller to look up why", func(t *testing.T) {
+		conn := &mockElasticBeanstalkRefreshConn{
+			responses: []*elasticbeanstalk.DescribeApplicationVersionsOutput{
+				applicationVersionDescribeOutput("Failed"),
+			},
+		}
+		refresh := applicationVersionStateRefreshFunc(conn, "app", "v1")
+
+		if _, status, err := refresh(); err != nil || status != "Failed" {
+			t.Fatalf("expected Failed, got status=%q err=%v", status, err)
+		}
+	})
+
+	t.Run("treats a not-yet-visible version as pending, not an error", func(t *testing.T) {
+		conn := &mockElasticBeanstalkRefreshConn{
+			responses: []*elasticbeanstalk.DescribeApplicationVersionsOutput{
+				{ApplicationVersions: nil},
+				applicationVersionDescribeOutput("Processed"),
+			},
+		}
+		refresh := applicationVersionStateRefreshFunc(conn, "app", "v1")
+
+		if _, status, err := refresh(); err != nil || status != "Processing" {
+			t.Fatalf("expected a pending Processing status for a not-yet-visible version, got status=%q err=%v", status, err)
+		}
+		if _, status, err := refresh(); err != nil || status != "Processed" {
+			t.Fatalf("expected Processed once the version becomes visible, got status=%q err=%v", status, err)
+		}
+	})
+}
+
+func TestApplicationVersionFailureReason(t *testing.T) {
+	conn := &mockElasticBeanstalkRefreshConn{
+		eventMessage: "source bundle is missing a Dockerrun.aws.json",
+	}
+
+	got := applicationVersionFailureReason(conn, "app", "v1")
+	if got != "source bundle is missing a Dockerrun.aws.json" {
+		t.Errorf("got %q, want the Beanstalk event message", got)
+	}
+}
+
+// testAppVersion builds a synthetic ApplicationVersionDescription for
+// retention policy tests. olderBy orders versions relative to each other;
+// larger values are older.
+func testAppVersion(label string, olderBy time.Duration, sourceBundle *elasticbeanstalk.S3Location) *elasticbeanstalk.ApplicationVersionDescription {
+	return &elasticbeanstalk.ApplicationVersionDescription{
+		VersionLabel: aws.String(label),
+		DateCreated:  aws.Time(time.Now().Add(-olderBy)),
+		SourceBundle: sourceBundle,
+	}
+}
+
+func deletedLabels(deletable map[string]bool) []string {
+	labels := make([]string, 0, len(deletable))
+	for label := range deletable {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func TestCountPolicy_deletableVersions(t *testing.T) {
+	versions := []*elasticbeanstalk.ApplicationVersionDescription{
+		testAppVersion("v1", 0*time.Hour, nil),
+		testAppVersion("v2", 1*time.Hour, nil),
+		testAppVersion("v3", 2*time.Hour, nil),
+		testAppVersion("v4", 3*time.Hour, nil),
+		testAppVersion("v5", 4*time.Hour, nil),
+	}
+
+	testCases := []struct {
+		name string
+		keep int
+		want []string
+	}{
+		{name: "keeps newest 3, deletes the rest", keep: 3, want: []string{"v4", "v5"}},
+		{name: "keeps everything when under the limit", keep: 10, want: []string{}},
+		{name: "a non-positive keep deletes nothing", keep: 0, want: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := deletedLabels(countPolicy{keep: tc.keep}.deletableVersions(versions))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("keep=%d: got %v, want %v", tc.keep, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAgePolicy_deletableVersions(t *testing.T) {
+	versions := []*elasticbeanstalk.ApplicationVersionDescription{
+		testAppVersion("v1", 1*time.Hour, nil),
+		testAppVersion("v2", 30*time.Hour, nil),
+		testAppVersion("v3", 100*time.Hour, nil),
+	}
+
+	testCases := []struct {
+		name   string
+		maxAge time.Duration
+		want   []string
+	}{
+		{name: "deletes everything older than a day", maxAge: 24 * time.Hour, want: []string{"v2", "v3"}},
+		{name: "deletes nothing when max age is generous", maxAge: 1000 * time.Hour, want: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := deletedLabels(agePolicy{maxAge: tc.maxAge}.deletableVersions(versions))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("maxAge=%s: got %v, want %v", tc.maxAge, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegexGroupPolicy_deletableVersions(t *testing.T) {
+	// Within each branch group, lower-numbered versions are older.
+	versions := []*elasticbeanstalk.ApplicationVersionDescription{
+		testAppVersion("main-v3", 0*time.Hour, nil),
+		testAppVersion("main-v2", 1*time.Hour, nil),
+		testAppVersion("main-v1", 2*time.Hour, nil),
+		testAppVersion("feature-v2", 0*time.Hour, nil),
+		testAppVersion("feature-v1", 1*time.Hour, nil),
+		testAppVersion("unmatched", 0*time.Hour, nil),
+	}
+
+	policy := regexGroupPolicy{
+		pattern:      regexp.MustCompile(`^(.*)-v\d+$`),
+		keepPerGroup: 1,
+	}
+
+	got := deletedLabels(policy.deletableVersions(versions))
+	want := []string{"feature-v1", "main-v1", "main-v2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// mockS3Conn implements just enough of s3iface.S3API to back totalSizePolicy
+// in tests, embedding the interface so the rest of its large surface is
+// satisfied without stubbing every method.
+type mockS3Conn struct {
+	s3iface.S3API
+	sizes map[string]int64
+}
+
+func (m *mockS3Conn) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	size, ok := m.sizes[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New("NotFound", "no such object", nil)
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(size)}, nil
+}
+
+func TestTotalSizePolicy_deletableVersions(t *testing.T) {
+	bundle := func(key string) *elasticbeanstalk.S3Location {
+		return &elasticbeanstalk.S3Location{S3Bucket: aws.String("bucket"), S3Key: aws.String(key)}
+	}
+
+	versions := []*elasticbeanstalk.ApplicationVersionDescription{
+		testAppVersion("v1", 0*time.Hour, bundle("v1.zip")), // newest
+		testAppVersion("v2", 1*time.Hour, bundle("v2.zip")),
+		testAppVersion("v3", 2*time.Hour, bundle("v3.zip")), // oldest
+	}
+
+	conn := &mockS3Conn{sizes: map[string]int64{
+		"v1.zip": 100,
+		"v2.zip": 100,
+		"v3.zip": 100,
+	}}
+
+	policy := totalSizePolicy{maxTotalBytes: 150, s3conn: conn}
+
+	got := deletedLabels(policy.deletableVersions(versions))
+	want := []string{"v2", "v3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDeletableApplicationVersions(t *testing.T) {
+	versions := []*elasticbeanstalk.ApplicationVersionDescription{
+		testAppVersion("v1", 0*time.Hour, nil),
+		testAppVersion("v2", 1*time.Hour, nil),
+		testAppVersion("v3", 2*time.Hour, nil),
+	}
+
+	t.Run("only deletes versions every policy agrees on", func(t *testing.T) {
+		policies := []retentionPolicy{
+			countPolicy{keep: 1},               // deletable: v2, v3
+			agePolicy{maxAge: 90 * time.Minute}, // deletable: v3
+		}
+
+		got := deletableApplicationVersions(versions, policies, map[string]bool{})
+		if len(got) != 1 || aws.StringValue(got[0].VersionLabel) != "v3" {
+			t.Errorf("got %v, want just v3", got)
+		}
+	})
+
+	t.Run("never deletes a deployed version", func(t *testing.T) {
+		policies := []retentionPolicy{agePolicy{maxAge: 0}} // deletable: v1, v2, v3
+		deployed := map[string]bool{"v2": true}
+
+		got := deletableApplicationVersions(versions, policies, deployed)
+		var gotLabels []string
+		for _, v := range got {
+			gotLabels = append(gotLabels, aws.StringValue(v.VersionLabel))
+		}
+		sort.Strings(gotLabels)
+
+		want := []string{"v1", "v3"}
+		if !reflect.DeepEqual(gotLabels, want) {
+			t.Errorf("got %v, want %v", gotLabels, want)
+		}
+	})
+
+	t.Run("no policies means nothing is deletable", func(t *testing.T) {
+		got := deletableApplicationVersions(versions, nil, map[string]bool{})
+		if len(got) != 0 {
+			t.Errorf("got %v, want no candidates", got)
+		}
+	})
+}
+
+func TestDiffTagsEB(t *testing.T) {
+	testCases := []struct {
+		name       string
+		old        map[string]interface{}
+		new        map[string]interface{}
+		wantAdd    []*elasticbeanstalk.Tag
+		wantRemove []string
+	}{
+		{
+			name:    "adds a new tag",
+			old:     map[string]interface{}{},
+			new:     map[string]interface{}{"Name": "test"},
+			wantAdd: []*elasticbeanstalk.Tag{{Key: aws.String("Name"), Value: aws.String("test")}},
+		},
+		{
+			name:    "updates a changed value",
+			old:     map[string]interface{}{"Name": "old"},
+			new:     map[string]interface{}{"Name": "new"},
+			wantAdd: []*elasticbeanstalk.Tag{{Key: aws.String("Name"), Value: aws.String("new")}},
+		},
+		{
+			name:       "removes a dropped tag",
+			old:        map[string]interface{}{"Name": "test"},
+			new:        map[string]interface{}{},
+			wantRemove: []string{"Name"},
+		},
+		{
+			name: "leaves an unchanged tag alone",
+			old:  map[string]interface{}{"Name": "test"},
+			new:  map[string]interface{}{"Name": "test"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotAdd, gotRemove := diffTagsEB(tc.old, tc.new)
+
+			sort.Slice(gotAdd, func(i, j int) bool {
+				return aws.StringValue(gotAdd[i].Key) < aws.StringValue(gotAdd[j].Key)
+			})
+			if !reflect.DeepEqual(gotAdd, tc.wantAdd) {
+				t.Errorf("tagsToAdd: got %v, want %v", gotAdd, tc.wantAdd)
+			}
+
+			var gotRemoveKeys []string
+			for _, k := range gotRemove {
+				gotRemoveKeys = append(gotRemoveKeys, aws.StringValue(k))
+			}
+			sort.Strings(gotRemoveKeys)
+			if !reflect.DeepEqual(gotRemoveKeys, tc.wantRemove) {
+				t.Errorf("tagsToRemove: got %v, want %v", gotRemoveKeys, tc.wantRemove)
+			}
+		})
+	}
+}
+
+func TestAccAWSElasticBeanstalkApplicationVersion_deleteSourceBundle(t *testing.T) {
+	appName := fmt.Sprintf("tf-test-name-%s", acctest.RandString(8))
+	bucketName := fmt.Sprintf("tftest-eb-appversion-%s", acctest.RandString(8))
+	key := "beanstalk-app-version.zip"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckElasticBeanstalkApplicationVersionDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccElasticBeanstalkApplicationVersionDeleteSourceBundleConfig(appName, bucketName, key, true),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckElasticBeanstalkApplicationVersionExists("aws_elastic_beanstalk_application_version.default"),
+					testAccCheckS3ObjectExists(bucketName, key),
+				),
+			},
+			{
+				// Drops the application_version resource from the config so
+				// Terraform destroys it, then asserts the source bundle was
+				// deleted alongside it.
+				Config: testAccElasticBeanstalkApplicationVersionRemovedConfig(appName, bucketName, key),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckS3ObjectDoesNotExist(bucketName, key),
+				),
+			},
+		},
+	})
+}
+
+func TestAccAWSElasticBeanstalkApplicationVersion_keepSourceBundle(t *testing.T) {
+	appName := fmt.Sprintf("tf-test-name-%s", acctest.RandString(8))
+	bucketName := fmt.Sprintf("tftest-eb-appversion-%s", acctest.RandString(8))
+	key := "beanstalk-app-version.zip"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckElasticBeanstalkApplicationVersionDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccElasticBeanstalkApplicationVersionDeleteSourceBundleConfig(appName, bucketName, key, false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckElasticBeanstalkApplicationVersionExists("aws_elastic_beanstalk_application_version.default"),
+					testAccCheckS3ObjectExists(bucketName, key),
+				),
+			},
+			{
+				// Same destroy step, but this time the bundle must survive
+				// since delete_source_bundle was false.
+				Config: testAccElasticBeanstalkApplicationVersionRemovedConfig(appName, bucketName, key),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckS3ObjectExists(bucketName, key),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckElasticBeanstalkApplicationVersionExists(n string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		_, ok := s.RootModule().Resources[n]
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckElasticBeanstalkApplicationVersionDestroy(s *terraform.State) error {
+	conn := testAccProvider.Meta().(*AWSClient).elasticbeanstalkconn
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "aws_elastic_beanstalk_application_version" {
+			continue
+		}
+
+		resp, err := conn.DescribeApplicationVersions(&elasticbeanstalk.DescribeApplicationVersionsInput{
+			VersionLabels: []*string{aws.String(rs.Primary.ID)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.ApplicationVersions) > 0 {
+			return fmt.Errorf("Elastic Beanstalk Application Version %s still exists", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+func testAccCheckS3ObjectExists(bucket, key string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		conn := testAccProvider.Meta().(*AWSClient).s3conn
+		_, err := conn.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("expected source bundle s3://%s/%s to still exist, got: %s", bucket, key, err)
+		}
+		return nil
+	}
+}
+
+func testAccCheckS3ObjectDoesNotExist(bucket, key string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		conn := testAccProvider.Meta().(*AWSClient).s3conn
+		_, err := conn.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err == nil {
+			return fmt.Errorf("expected source bundle s3://%s/%s to have been deleted alongside the application version", bucket, key)
+		}
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "NotFound" {
+			return fmt.Errorf("expected a NotFound error for s3://%s/%s, got: %s", bucket, key, err)
+		}
+		return nil
+	}
+}
+
+func testAccElasticBeanstalkApplicationVersionDeleteSourceBundleConfig(appName, bucketName, key string, deleteSourceBundle bool) string {
+	return fmt.Sprintf(`
+resource "aws_s3_bucket" "default" {
+  bucket = "%s"
+}
+
+resource "aws_s3_bucket_object" "default" {
+  bucket  = "${aws_s3_bucket.default.id}"
+  key     = "%s"
+  content = "contents"
+}
+
+resource "aws_elastic_beanstalk_application" "default" {
+  name = "%s"
+}
+
+resource "aws_elastic_beanstalk_application_version" "default" {
+  application          = "${aws_elastic_beanstalk_application.default.name}"
+  name                 = "%s-version"
+  bucket               = "${aws_s3_bucket.default.id}"
+  key                  = "${aws_s3_bucket_object.default.id}"
+  delete_source_bundle = %t
+}
+`, bucketName, key, appName, appName, deleteSourceBundle)
+}
+
+// testAccElasticBeanstalkApplicationVersionRemovedConfig is the same
+// bucket/object/application as above with the application_version resource
+// dropped, so applying it destroys the version and lets a test assert on the
+// source bundle's post-destroy state.
+func testAccElasticBeanstalkApplicationVersionRemovedConfig(appName, bucketName, key string) string {
+	return fmt.Sprintf(`
+resource "aws_s3_bucket" "default" {
+  bucket = "%s"
+}
+
+resource "aws_s3_bucket_object" "default" {
+  bucket  = "${aws_s3_bucket.default.id}"
+  key     = "%s"
+  content = "contents"
+}
+
+resource "aws_elastic_beanstalk_application" "default" {
+  name = "%s"
+}
+`, bucketName, key, appName)
+}
+
+func TestAccAWSElasticBeanstalkApplicationVersion_tags(t *testing.T) {
+	appName := fmt.Sprintf("tf-test-name-%s", acctest.RandString(8))
+	bucketName := fmt.Sprintf("tftest-eb-appversion-%s", acctest.RandString(8))
+	key := "beanstalk-app-version.zip"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckElasticBeanstalkApplicationVersionDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccElasticBeanstalkApplicationVersionTagsConfig(appName, bucketName, key, "test"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckElasticBeanstalkApplicationVersionExists("aws_elastic_beanstalk_application_version.default"),
+					resource.TestCheckResourceAttr("aws_elastic_beanstalk_application_version.default", "tags.%", "1"),
+					resource.TestCheckResourceAttr("aws_elastic_beanstalk_application_version.default", "tags.Environment", "test"),
+				),
+			},
+			{
+				// Same resource, tag value changed in place (no ForceNew).
+				Config: testAccElasticBeanstalkApplicationVersionTagsConfig(appName, bucketName, key, "production"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckElasticBeanstalkApplicationVersionExists("aws_elastic_beanstalk_application_version.default"),
+					resource.TestCheckResourceAttr("aws_elastic_beanstalk_application_version.default", "tags.%", "1"),
+					resource.TestCheckResourceAttr("aws_elastic_beanstalk_application_version.default", "tags.Environment", "production"),
+				),
+			},
+		},
+	})
+}
+
+func testAccElasticBeanstalkApplicationVersionTagsConfig(appName, bucketName, key, environment string) string {
+	return fmt.Sprintf(`
+resource "aws_s3_bucket" "default" {
+  bucket = "%s"
+}
+
+resource "aws_s3_bucket_object" "default" {
+  bucket  = "${aws_s3_bucket.default.id}"
+  key     = "%s"
+  content = "contents"
+}
+
+resource "aws_elastic_beanstalk_application" "default" {
+  name = "%s"
+}
+
+resource "aws_elastic_beanstalk_application_version" "default" {
+  application = "${aws_elastic_beanstalk_application.default.name}"
+  name        = "%s-version"
+  bucket      = "${aws_s3_bucket.default.id}"
+  key         = "${aws_s3_bucket_object.default.id}"
+
+  tags {
+    Environment = "%s"
+  }
+}
+`, bucketName, key, appName, appName, environment)
+}